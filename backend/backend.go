@@ -0,0 +1,98 @@
+// Package backend abstracts the file system operations the
+// exporter needs in order to walk and watch a Maildir tree.
+// This lets Maildirs that are not reachable through a plain
+// local mount (e.g. exposed over WebDAV) be monitored the same
+// way as a directory on disk.
+package backend
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Entry describes one file system element returned by a
+// Backend implementation. It mirrors the handful of
+// os.FileInfo fields the exporter actually needs, so that
+// non-local backends do not have to synthesize one.
+type Entry struct {
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+// EventOp identifies the kind of change carried by an Event.
+type EventOp int
+
+// The set of change kinds a Backend can report through Watch.
+const (
+	Create EventOp = iota
+	Write
+	Remove
+	Rename
+
+	// Overflow reports that the Backend's underlying watch
+	// mechanism failed or dropped events (e.g. an fsnotify
+	// queue overflow), so the path watched can no longer be
+	// trusted to have reported every change. Event.Path is
+	// empty, since the loss is not scoped to a single path.
+	Overflow
+)
+
+// Event describes a single change observed below a watched
+// path.
+type Event struct {
+	Path string
+	Op   EventOp
+}
+
+// Backend abstracts the file system operations the exporter
+// needs to walk and watch a Maildir tree, so that Maildirs
+// living on remote storage (WebDAV, IMAP-backed, S3, ...) can
+// be monitored the same way as a local mount.
+type Backend interface {
+
+	// ReadDir lists the immediate children of path.
+	ReadDir(path string) ([]Entry, error)
+
+	// Stat returns the Entry describing path itself.
+	Stat(path string) (Entry, error)
+
+	// Watch starts observing path for changes and streams
+	// them on the returned channel until Close is called on
+	// the Backend. Implementations that cannot watch natively
+	// fall back to polling. The channel is shared across all
+	// paths watched through the same Backend instance.
+	Watch(path string) (<-chan Event, error)
+
+	// Close releases any resources held by the Backend, in
+	// particular stopping all outstanding Watch goroutines.
+	Close() error
+}
+
+// New resolves rawURL to a concrete Backend based on its
+// scheme: "file://" (or no scheme) selects the local file
+// system backend, "webdav://" selects the WebDAV backend,
+// polling every pollInterval since WebDAV has no native change
+// notification.
+func New(rawURL, user, password string, pollInterval time.Duration) (Backend, error) {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("backend: parsing %q: %w", rawURL, err)
+	}
+
+	switch u.Scheme {
+
+	case "", "file":
+		return NewLocalBackend()
+
+	case "webdav":
+		root := (&url.URL{Scheme: "https", Host: u.Host, Path: u.Path}).String()
+		return NewWebDAVBackend(root, user, password, pollInterval), nil
+
+	default:
+		return nil, fmt.Errorf("backend: unsupported scheme %q", u.Scheme)
+	}
+}
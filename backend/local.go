@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// LocalBackend implements Backend on top of the local file
+// system, preserving the exporter's original fsnotify-based
+// change detection.
+type LocalBackend struct {
+	watcher *fsnotify.Watcher
+	events  chan Event
+}
+
+// NewLocalBackend creates a Backend backed by the local file
+// system.
+func NewLocalBackend() (*LocalBackend, error) {
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &LocalBackend{
+		watcher: w,
+		events:  make(chan Event),
+	}
+
+	go b.forward()
+
+	return b, nil
+}
+
+// ReadDir lists the immediate children of path.
+func (b *LocalBackend) ReadDir(path string) ([]Entry, error) {
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(files))
+	for _, f := range files {
+		entries = append(entries, entryFromFileInfo(f))
+	}
+
+	return entries, nil
+}
+
+// Stat returns the Entry describing path itself.
+func (b *LocalBackend) Stat(path string) (Entry, error) {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return entryFromFileInfo(info), nil
+}
+
+// Watch adds path to the underlying fsnotify watcher. All
+// watched paths share the same event stream, matching the
+// original single-watcher-per-user behaviour.
+func (b *LocalBackend) Watch(path string) (<-chan Event, error) {
+
+	if err := b.watcher.Add(path); err != nil {
+		return nil, err
+	}
+
+	return b.events, nil
+}
+
+// Close stops the fsnotify watcher and the forwarding
+// goroutine.
+func (b *LocalBackend) Close() error {
+	return b.watcher.Close()
+}
+
+// forward translates raw fsnotify events into backend Events
+// until the watcher is closed.
+func (b *LocalBackend) forward() {
+
+	defer close(b.events)
+
+	for {
+		select {
+
+		case ev, ok := <-b.watcher.Events:
+			if !ok {
+				return
+			}
+
+			b.events <- Event{Path: ev.Name, Op: fsnotifyOpToEventOp(ev.Op)}
+
+		case _, ok := <-b.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			// The watcher reported an error, which for fsnotify
+			// includes its internal queue overflowing; either way
+			// events may have been lost, so the caller needs to
+			// fall back to a full walk rather than trust the
+			// watch alone from here on.
+			b.events <- Event{Op: Overflow}
+		}
+	}
+}
+
+func fsnotifyOpToEventOp(op fsnotify.Op) EventOp {
+
+	switch {
+	case op&fsnotify.Create != 0:
+		return Create
+	case op&fsnotify.Remove != 0:
+		return Remove
+	case op&fsnotify.Rename != 0:
+		return Rename
+	default:
+		return Write
+	}
+}
+
+func entryFromFileInfo(info os.FileInfo) Entry {
+
+	return Entry{
+		Name:    info.Name(),
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+}
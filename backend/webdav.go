@@ -0,0 +1,184 @@
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// WebDAVBackend implements Backend on top of a WebDAV share
+// via the gowebdav client. WebDAV exposes no native change
+// notification, so Watch falls back to polling every
+// pollInterval.
+type WebDAVBackend struct {
+	client       *gowebdav.Client
+	pollInterval time.Duration
+
+	mu       sync.Mutex
+	watched  map[string]struct{}
+	lastSeen map[string]map[string]Entry
+
+	events chan Event
+	stop   chan struct{}
+}
+
+// NewWebDAVBackend creates a Backend talking to the WebDAV
+// share at root, authenticating with user/password, and
+// polling every watched path every pollInterval for changes.
+func NewWebDAVBackend(root, user, password string, pollInterval time.Duration) *WebDAVBackend {
+
+	return &WebDAVBackend{
+		client:       gowebdav.NewClient(root, user, password),
+		pollInterval: pollInterval,
+		watched:      make(map[string]struct{}),
+		lastSeen:     make(map[string]map[string]Entry),
+		events:       make(chan Event),
+		stop:         make(chan struct{}),
+	}
+}
+
+// ReadDir lists the immediate children of path.
+func (b *WebDAVBackend) ReadDir(path string) ([]Entry, error) {
+
+	files, err := b.client.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(files))
+	for _, f := range files {
+		entries = append(entries, Entry{
+			Name:    f.Name(),
+			IsDir:   f.IsDir(),
+			Size:    f.Size(),
+			ModTime: f.ModTime(),
+		})
+	}
+
+	return entries, nil
+}
+
+// Stat returns the Entry describing path itself.
+func (b *WebDAVBackend) Stat(path string) (Entry, error) {
+
+	info, err := b.client.Stat(path)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return Entry{
+		Name:    info.Name(),
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+// Watch starts polling path for changes if it is not already
+// being polled, and returns the shared event stream.
+func (b *WebDAVBackend) Watch(path string) (<-chan Event, error) {
+
+	b.mu.Lock()
+	_, already := b.watched[path]
+	b.watched[path] = struct{}{}
+	b.mu.Unlock()
+
+	if !already {
+		go b.poll(path)
+	}
+
+	return b.events, nil
+}
+
+// Close stops all outstanding polling goroutines.
+func (b *WebDAVBackend) Close() error {
+	close(b.stop)
+	return nil
+}
+
+// poll periodically diffs the directory listing for path
+// against the previous listing, emitting a synthetic Event for
+// every entry that was added, removed, or changed size or
+// modification time.
+func (b *WebDAVBackend) poll(path string) {
+
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	// Record the listing as it stands now, so the first tick
+	// reports changes made since Watch rather than every entry.
+	b.pollOnce(path)
+
+	for {
+		select {
+
+		case <-ticker.C:
+			b.pollOnce(path)
+
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *WebDAVBackend) pollOnce(path string) {
+
+	entries, err := b.ReadDir(path)
+	if err != nil {
+		return
+	}
+
+	current := make(map[string]Entry, len(entries))
+	for _, e := range entries {
+		current[e.Name] = e
+	}
+
+	b.mu.Lock()
+	previous, seen := b.lastSeen[path]
+	b.lastSeen[path] = current
+	b.mu.Unlock()
+
+	if !seen {
+		return
+	}
+
+	for name, entry := range current {
+
+		prev, existed := previous[name]
+		if !existed {
+			if !b.send(Event{Path: path + "/" + name, Op: Create}) {
+				return
+			}
+			continue
+		}
+
+		if prev.Size != entry.Size || !prev.ModTime.Equal(entry.ModTime) {
+			if !b.send(Event{Path: path + "/" + name, Op: Write}) {
+				return
+			}
+		}
+	}
+
+	for name := range previous {
+		if _, stillThere := current[name]; !stillThere {
+			if !b.send(Event{Path: path + "/" + name, Op: Remove}) {
+				return
+			}
+		}
+	}
+}
+
+// send delivers ev on b.events, giving up and reporting false
+// if b.stop is closed first. Without this, a poll tick racing
+// Close with nothing left reading b.events would block the
+// polling goroutine forever instead of letting it exit.
+func (b *WebDAVBackend) send(ev Event) bool {
+
+	select {
+	case b.events <- ev:
+		return true
+	case <-b.stop:
+		return false
+	}
+}
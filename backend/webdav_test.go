@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+// TestWebDAVBackendSendUnblocksOnClose reproduces the poll
+// goroutine leak: if nothing is left reading b.events (e.g.
+// because the caller's watch loop already exited) and Close is
+// called, a pending send must give up rather than block
+// forever.
+func TestWebDAVBackendSendUnblocksOnClose(t *testing.T) {
+
+	b := NewWebDAVBackend("https://example.invalid", "", "", time.Second)
+
+	b.Close()
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- b.send(Event{Path: "/u/cur/msg", Op: Create})
+	}()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Errorf("send() = true after Close with no consumer, want false")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send() blocked after Close with no consumer")
+	}
+}
+
+// TestWebDAVBackendSendDeliversToConsumer checks the ordinary
+// path still works: a send with a live reader and an open
+// backend succeeds.
+func TestWebDAVBackendSendDeliversToConsumer(t *testing.T) {
+
+	b := NewWebDAVBackend("https://example.invalid", "", "", time.Second)
+	defer b.Close()
+
+	ev := Event{Path: "/u/cur/msg", Op: Create}
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- b.send(ev)
+	}()
+
+	select {
+	case got := <-b.events:
+		if got != ev {
+			t.Errorf("received %+v, want %+v", got, ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send() did not deliver to a live consumer")
+	}
+
+	if ok := <-done; !ok {
+		t.Errorf("send() = false with a live consumer, want true")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-pluto/maildir_exporter/backend"
+)
+
+// discoverRoots builds the initial set of UserMaildirs found
+// below any of roots, one per direct subdirectory, so that
+// sharded mailstores (e.g. "/var/mail/a-h", "/var/mail/i-z")
+// are monitored as a single pool of users.
+func discoverRoots(roots []string, backendURL, backendUser, backendPassword string, pollInterval time.Duration) ([]*UserMaildir, error) {
+
+	var all []*UserMaildir
+
+	for _, root := range roots {
+
+		userMaildirs, err := walkRootMaildir(root, backendURL, backendUser, backendPassword, pollInterval)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, userMaildirs...)
+	}
+
+	return all, nil
+}
+
+// watchRoot watches root itself for users being provisioned or
+// deprovisioned at runtime: on directory creation it builds a
+// new UserMaildir and sends it on added; on directory removal
+// it sends the removed user's path on removed. It runs until
+// done is closed.
+func watchRoot(logger log.Logger, metrics *Metrics, root, backendURL, backendUser, backendPassword string, pollInterval time.Duration, added chan<- *UserMaildir, removed chan<- string, done <-chan struct{}) {
+
+	rootBackend, err := backend.New(backendURL, backendUser, backendPassword, pollInterval)
+	if err != nil {
+		level.Error(logger).Log("msg", "error creating backend for root watcher", "root", root, "err", err)
+		return
+	}
+	defer rootBackend.Close()
+
+	events, err := rootBackend.Watch(root)
+	if err != nil {
+		level.Error(logger).Log("msg", "error watching Maildir root", "root", root, "err", err)
+		return
+	}
+
+	for {
+		select {
+
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+
+			switch ev.Op {
+
+			case backend.Create:
+
+				entry, err := rootBackend.Stat(ev.Path)
+				if err != nil || !entry.IsDir {
+					continue
+				}
+
+				b, err := backend.New(backendURL, backendUser, backendPassword, pollInterval)
+				if err != nil {
+					level.Error(logger).Log("msg", "error creating backend for new user", "path", ev.Path, "err", err)
+					continue
+				}
+
+				added <- &UserMaildir{
+					userPath:     ev.Path,
+					backend:      b,
+					walkTrigger:  make(chan struct{}),
+					watchTrigger: make(chan struct{}),
+					done:         make(chan struct{}),
+				}
+
+				metrics.userLifecycleEvents.With(prometheus.Labels{"event": "added"}).Inc()
+
+			case backend.Remove:
+
+				removed <- filepath.Join(root, filepath.Base(ev.Path))
+				metrics.userLifecycleEvents.With(prometheus.Labels{"event": "removed"}).Inc()
+			}
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// superviseUsers starts the walk/watch/reconcile goroutines for
+// every initially discovered user, then keeps that set in sync
+// as users are added or removed at runtime, registering and
+// unregistering their metrics accordingly. It blocks until done
+// is closed.
+func superviseUsers(logger log.Logger, metrics *Metrics, userMaildirs []*UserMaildir, walkDone chan struct{}, added <-chan *UserMaildir, removed <-chan string, done <-chan struct{}) {
+
+	users := make(map[string]*UserMaildir, len(userMaildirs))
+
+	start := func(u *UserMaildir) {
+
+		users[u.userPath] = u
+
+		events, err := u.backend.Watch(u.userPath)
+		if err != nil {
+			level.Error(logger).Log("msg", "error watching new user's Maildir", "user", u.userPath, "err", err)
+		}
+
+		go u.walk(logger, metrics, walkDone)
+		go u.reconcileLoop(*fullWalkInterval)
+
+		if err == nil {
+			go u.watch(logger, metrics, events)
+		}
+
+		u.walkTrigger <- struct{}{}
+	}
+
+	for _, u := range userMaildirs {
+		start(u)
+	}
+
+	metrics.users.Set(float64(len(users)))
+
+	for {
+		select {
+
+		case u := <-added:
+
+			start(u)
+			metrics.users.Set(float64(len(users)))
+
+		case userPath := <-removed:
+
+			u, ok := users[userPath]
+			if !ok {
+				continue
+			}
+
+			close(u.done)
+			u.backend.Close()
+			metrics.unregisterUser(userPath)
+			delete(users, userPath)
+
+			metrics.users.Set(float64(len(users)))
+
+		case <-done:
+			return
+		}
+	}
+}
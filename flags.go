@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+// backendURL, backendUser and backendPassword select and
+// authenticate against the storage backend (see package
+// backend) that every configured Maildir root is walked and
+// watched through. backendPollInterval governs backends (such
+// as WebDAV) that have no native watch support and fall back to
+// polling.
+var (
+	backendURL = flag.String("backend", "file://", "Storage backend URL for the monitored Maildirs (file:// or webdav://).")
+
+	backendUser     = flag.String("backend-user", "", "Username for authenticating against the storage backend, if required.")
+	backendPassword = flag.String("backend-password", "", "Password for authenticating against the storage backend, if required.")
+
+	backendPollInterval = flag.Duration("backend-poll-interval", 30*time.Second,
+		"Polling interval for storage backends without native change notification (e.g. webdav://).")
+)
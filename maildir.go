@@ -0,0 +1,162 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/go-pluto/maildir_exporter/backend"
+)
+
+// UserMaildir represents the current file system state
+// tracked for one user's Maildir directory. It coordinates
+// the periodic walk and the Backend watch responsible for
+// triggering it.
+type UserMaildir struct {
+	userPath string
+	backend  backend.Backend
+
+	walkTrigger  chan struct{}
+	watchTrigger chan struct{}
+	done         chan struct{}
+
+	// merkleMu guards merkleCache, the per-directory Merkle
+	// hash cache built up by merkleHash and invalidated by
+	// invalidate as fsnotify events arrive.
+	merkleMu    sync.Mutex
+	merkleCache map[string]*dirNode
+
+	// countersMu guards counters, the running totals kept up
+	// to date either by a full walk or by applyDelta's
+	// incremental updates. estimated is true whenever counters
+	// was last touched incrementally rather than by a full
+	// walk, so the next full walk knows to check it for drift.
+	countersMu sync.Mutex
+	counters   maildirCounters
+	estimated  bool
+
+	// mailboxesMu guards mailboxes, the running per-mailbox
+	// counters kept up to date either by a full walk or by
+	// applyMailboxDelta's incremental updates.
+	mailboxesMu sync.Mutex
+	mailboxes   map[string]*mailboxStats
+}
+
+// maildirCounters holds the aggregate counters a walk (full or
+// incremental) keeps up to date for a user's Maildir.
+type maildirCounters struct {
+	elements float64
+	folders  float64
+	files    float64
+	size     float64
+}
+
+// walkMaildir recursively visits every entry found below root
+// through this user's Backend, calling fn with each entry's
+// full path before recursing into it if it is itself a
+// directory. Unlike filepath.Walk, this never touches the
+// local file system directly, so it works the same way
+// regardless of which Backend this user was configured with.
+func (m *UserMaildir) walkMaildir(root string, fn func(path string, entry backend.Entry) error) error {
+
+	entries, err := m.backend.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+
+		path := filepath.Join(root, entry.Name)
+
+		if err := fn(path, entry); err != nil {
+			return err
+		}
+
+		if entry.IsDir {
+			if err := m.walkMaildir(path, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// mailboxStats accumulates the per-mailbox counters observed
+// while walking a single user's Maildir. A mailbox is any
+// directory directly containing at least one of new/, cur/ or
+// tmp/, typically the Maildir root itself (INBOX) or one of its
+// Maildir++ style dot subfolders (e.g. .Sent, .Archive).
+type mailboxStats struct {
+	newMessages float64
+	curMessages float64
+	tmpMessages float64
+
+	flagCounts map[byte]float64
+
+	oldestMessage float64
+	newestMessage float64
+}
+
+// newMailboxStats creates a zeroed mailboxStats ready to
+// accumulate counters for a newly discovered mailbox.
+func newMailboxStats() *mailboxStats {
+
+	return &mailboxStats{
+		flagCounts: make(map[byte]float64),
+	}
+}
+
+// observeTimestamp widens the oldest/newest bounds tracked
+// for this mailbox with an additional message's modification
+// time, expressed as a Unix timestamp in seconds.
+func (s *mailboxStats) observeTimestamp(unixSeconds float64) {
+
+	if s.oldestMessage == 0 || unixSeconds < s.oldestMessage {
+		s.oldestMessage = unixSeconds
+	}
+
+	if unixSeconds > s.newestMessage {
+		s.newestMessage = unixSeconds
+	}
+}
+
+// mailboxName derives the user-facing mailbox label for a
+// Maildir folder from its path relative to the user's
+// Maildir root. The root itself is labelled "INBOX", and
+// Maildir++ subfolders have their leading dot stripped.
+func mailboxName(relPath string) string {
+
+	if relPath == "." {
+		return "INBOX"
+	}
+
+	if len(relPath) > 0 && relPath[0] == '.' {
+		return relPath[1:]
+	}
+
+	return relPath
+}
+
+// maildirFlags are the single-letter info flags defined by
+// the Maildir filename convention, in their canonical order.
+var maildirFlags = []byte{'D', 'F', 'P', 'R', 'S', 'T'}
+
+// parseMaildirFlags extracts the info flags from a message
+// file name following the "<unique>:2,<flags>" convention. It
+// returns nil if the file name does not carry a flags suffix.
+func parseMaildirFlags(name string) []byte {
+
+	idx := -1
+	for i := len(name) - 1; i >= 0; i-- {
+		if name[i] == ':' {
+			idx = i
+			break
+		}
+	}
+
+	if idx == -1 || idx+3 > len(name) || name[idx+1:idx+3] != "2," {
+		return nil
+	}
+
+	return []byte(name[idx+3:])
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMaildirFlags(t *testing.T) {
+
+	cases := []struct {
+		name string
+		want []byte
+	}{
+		{"1626188000.M123P456.host:2,S", []byte("S")},
+		{"1626188000.M123P456.host:2,FRS", []byte("FRS")},
+		{"1626188000.M123P456.host:2,", []byte{}},
+		{"1626188000.M123P456.host", nil},
+		{"1626188000.M123P456.host:2", nil},
+		{"", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+
+			got := parseMaildirFlags(c.name)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("parseMaildirFlags(%q) = %v, want %v", c.name, got, c.want)
+			}
+		})
+	}
+}
+
+func TestMailboxName(t *testing.T) {
+
+	cases := []struct {
+		relPath string
+		want    string
+	}{
+		{".", "INBOX"},
+		{".Sent", "Sent"},
+		{".Archive.2020", "Archive.2020"},
+		{"Sent", "Sent"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.relPath, func(t *testing.T) {
+
+			if got := mailboxName(c.relPath); got != c.want {
+				t.Errorf("mailboxName(%q) = %q, want %q", c.relPath, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dirNode is one cached node of a user's Merkle tree, mirroring
+// the on-disk directory structure below their Maildir root.
+// Its hash is the SHA-256 over the sorted list of
+// (name, size, mtime, child-hash-or-file-hash) tuples of its
+// immediate children.
+type dirNode struct {
+	children   map[string]*dirNode
+	fileHashes map[string][32]byte
+	hash       [32]byte
+	dirty      bool
+}
+
+// merkleRoot returns the root hash of this user's Merkle tree,
+// recomputing any directory marked dirty (see invalidate) and
+// reusing cached hashes everywhere else.
+func (m *UserMaildir) merkleRoot() ([32]byte, error) {
+	return m.merkleHash(m.userPath)
+}
+
+// merkleHash computes, or reuses from cache, the Merkle hash of
+// the directory tree rooted at path, reading it through this
+// user's Backend rather than the local file system directly.
+// Only dirty or previously unseen directories are actually
+// re-read; children that disappeared since the directory was
+// last hashed are pruned from the cache as they're found.
+func (m *UserMaildir) merkleHash(path string) ([32]byte, error) {
+
+	m.merkleMu.Lock()
+	previous, ok := m.merkleCache[path]
+	clean := ok && !previous.dirty
+	m.merkleMu.Unlock()
+
+	if clean {
+		return previous.hash, nil
+	}
+
+	entries, err := m.backend.ReadDir(path)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	node := &dirNode{
+		children:   make(map[string]*dirNode),
+		fileHashes: make(map[string][32]byte),
+	}
+
+	names := make([]string, 0, len(entries))
+	sums := make(map[string][32]byte, len(entries))
+
+	for _, entry := range entries {
+
+		childPath := filepath.Join(path, entry.Name)
+		names = append(names, entry.Name)
+
+		if entry.IsDir {
+
+			childHash, err := m.merkleHash(childPath)
+			if err != nil {
+				return [32]byte{}, err
+			}
+
+			m.merkleMu.Lock()
+			node.children[entry.Name] = m.merkleCache[childPath]
+			m.merkleMu.Unlock()
+
+			meta := hashFileTuple(entry.Name, entry.Size, entry.ModTime)
+			sums[entry.Name] = sha256.Sum256(append(meta[:], childHash[:]...))
+			continue
+		}
+
+		fileHash := hashFileTuple(entry.Name, entry.Size, entry.ModTime)
+		node.fileHashes[entry.Name] = fileHash
+		sums[entry.Name] = fileHash
+	}
+
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		sum := sums[name]
+		h.Write([]byte(name))
+		h.Write(sum[:])
+	}
+
+	copy(node.hash[:], h.Sum(nil))
+
+	m.merkleMu.Lock()
+
+	if m.merkleCache == nil {
+		m.merkleCache = make(map[string]*dirNode)
+	}
+
+	// Any subdirectory the previous cached node knew about that
+	// is no longer among this directory's entries was removed
+	// from disk; drop it (and everything cached below it)
+	// instead of leaking it forever.
+	if previous != nil {
+		for childName := range previous.children {
+			if _, stillExists := node.children[childName]; !stillExists {
+				m.pruneCacheLocked(filepath.Join(path, childName))
+			}
+		}
+	}
+
+	m.merkleCache[path] = node
+	m.merkleMu.Unlock()
+
+	return node.hash, nil
+}
+
+// pruneCacheLocked removes path and, recursively, every
+// subdirectory it had cached, from merkleCache. Callers must
+// hold merkleMu.
+func (m *UserMaildir) pruneCacheLocked(path string) {
+
+	node, ok := m.merkleCache[path]
+	if !ok {
+		return
+	}
+
+	delete(m.merkleCache, path)
+
+	for childName := range node.children {
+		m.pruneCacheLocked(filepath.Join(path, childName))
+	}
+}
+
+// hashFileTuple hashes a single entry's (name, size, mtime)
+// tuple: the Merkle leaf for a regular file, and the metadata
+// part of a subdirectory's tuple alongside its child hash.
+func hashFileTuple(name string, size int64, modTime time.Time) [32]byte {
+
+	h := sha256.New()
+	h.Write([]byte(name))
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(size))
+	h.Write(buf[:])
+
+	binary.BigEndian.PutUint64(buf[:], uint64(modTime.UnixNano()))
+	h.Write(buf[:])
+
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+
+	return sum
+}
+
+// invalidateAll discards this user's entire Merkle cache, so
+// the next merkleRoot call rehashes every directory from
+// scratch rather than reusing anything. Used when a Backend's
+// watch mechanism reports it may have missed events somewhere
+// under the user's tree (see backend.Overflow), where per-path
+// invalidate can no longer be trusted to cover everywhere a
+// change might have happened.
+func (m *UserMaildir) invalidateAll() {
+
+	m.merkleMu.Lock()
+	m.merkleCache = make(map[string]*dirNode)
+	m.merkleMu.Unlock()
+}
+
+// invalidate marks path and all of its ancestors up to the
+// user's Maildir root as dirty, so the next merkleHash call
+// recomputes them from disk instead of returning a cached
+// value. Paths outside this user's tree are ignored.
+func (m *UserMaildir) invalidate(path string) {
+
+	rel, err := filepath.Rel(m.userPath, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return
+	}
+
+	m.merkleMu.Lock()
+	defer m.merkleMu.Unlock()
+
+	for {
+
+		if node, ok := m.merkleCache[path]; ok {
+			node.dirty = true
+		}
+
+		if path == m.userPath {
+			return
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return
+		}
+
+		path = parent
+	}
+}
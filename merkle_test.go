@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-pluto/maildir_exporter/backend"
+)
+
+// fakeBackend is a minimal in-memory backend.Backend used to
+// exercise merkleHash/invalidate without touching the real file
+// system. Its directory listings can be mutated between calls
+// to simulate files and folders appearing or disappearing.
+type fakeBackend struct {
+	dirs map[string][]backend.Entry
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{dirs: make(map[string][]backend.Entry)}
+}
+
+func (f *fakeBackend) ReadDir(path string) ([]backend.Entry, error) {
+
+	entries, ok := f.dirs[path]
+	if !ok {
+		return nil, fmt.Errorf("fakeBackend: no such directory %q", path)
+	}
+
+	return entries, nil
+}
+
+func (f *fakeBackend) Stat(path string) (backend.Entry, error) {
+	return backend.Entry{}, fmt.Errorf("fakeBackend: Stat not implemented")
+}
+
+func (f *fakeBackend) Watch(path string) (<-chan backend.Event, error) {
+	return nil, fmt.Errorf("fakeBackend: Watch not implemented")
+}
+
+func (f *fakeBackend) Close() error {
+	return nil
+}
+
+func newTestUserMaildir(b *fakeBackend) *UserMaildir {
+	return &UserMaildir{userPath: "/u", backend: b}
+}
+
+func TestMerkleHashCaches(t *testing.T) {
+
+	b := newFakeBackend()
+	b.dirs["/u"] = []backend.Entry{
+		{Name: "cur", ModTime: time.Unix(1, 0)},
+	}
+
+	m := newTestUserMaildir(b)
+
+	first, err := m.merkleHash("/u")
+	if err != nil {
+		t.Fatalf("merkleHash: %v", err)
+	}
+
+	// Change the underlying listing without invalidating: the
+	// cached hash must still be returned unchanged.
+	b.dirs["/u"] = []backend.Entry{
+		{Name: "cur", ModTime: time.Unix(2, 0)},
+	}
+
+	second, err := m.merkleHash("/u")
+	if err != nil {
+		t.Fatalf("merkleHash: %v", err)
+	}
+
+	if first != second {
+		t.Errorf("merkleHash changed without invalidate: %x != %x", first, second)
+	}
+}
+
+func TestMerkleHashRecomputesAfterInvalidate(t *testing.T) {
+
+	b := newFakeBackend()
+	b.dirs["/u"] = []backend.Entry{
+		{Name: "cur", ModTime: time.Unix(1, 0)},
+	}
+
+	m := newTestUserMaildir(b)
+
+	first, err := m.merkleHash("/u")
+	if err != nil {
+		t.Fatalf("merkleHash: %v", err)
+	}
+
+	b.dirs["/u"] = []backend.Entry{
+		{Name: "cur", ModTime: time.Unix(2, 0)},
+	}
+
+	m.invalidate("/u")
+
+	second, err := m.merkleHash("/u")
+	if err != nil {
+		t.Fatalf("merkleHash: %v", err)
+	}
+
+	if first == second {
+		t.Errorf("merkleHash returned stale hash after invalidate")
+	}
+}
+
+func TestMerkleHashPrunesDeletedSubdirectory(t *testing.T) {
+
+	b := newFakeBackend()
+	b.dirs["/u"] = []backend.Entry{
+		{Name: "cur", IsDir: true},
+	}
+	b.dirs[filepath.Join("/u", "cur")] = []backend.Entry{
+		{Name: "msg1", ModTime: time.Unix(1, 0)},
+	}
+
+	m := newTestUserMaildir(b)
+
+	if _, err := m.merkleHash("/u"); err != nil {
+		t.Fatalf("merkleHash: %v", err)
+	}
+
+	if _, ok := m.merkleCache[filepath.Join("/u", "cur")]; !ok {
+		t.Fatalf("expected cur/ to be cached after first hash")
+	}
+
+	// Simulate the subdirectory having been removed, then
+	// invalidate the root and recompute.
+	delete(b.dirs, filepath.Join("/u", "cur"))
+	b.dirs["/u"] = []backend.Entry{}
+
+	m.invalidate("/u")
+
+	if _, err := m.merkleHash("/u"); err != nil {
+		t.Fatalf("merkleHash: %v", err)
+	}
+
+	if _, ok := m.merkleCache[filepath.Join("/u", "cur")]; ok {
+		t.Errorf("expected cur/ to be pruned from merkleCache after deletion, still present")
+	}
+}
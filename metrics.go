@@ -0,0 +1,227 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace is the common Prometheus metric name prefix for
+// every collector exposed by this exporter.
+const namespace = "maildir"
+
+// Metrics bundles all Prometheus collectors describing the
+// contents of the monitored Maildirs.
+type Metrics struct {
+	elements *prometheus.GaugeVec
+	folders  *prometheus.GaugeVec
+	files    *prometheus.GaugeVec
+	size     *prometheus.GaugeVec
+
+	newMessages *prometheus.GaugeVec
+	curMessages *prometheus.GaugeVec
+	tmpMessages *prometheus.GaugeVec
+
+	messagesByFlag *prometheus.CounterVec
+
+	oldestMessageTimestamp *prometheus.GaugeVec
+	newestMessageTimestamp *prometheus.GaugeVec
+
+	messageSize *prometheus.HistogramVec
+
+	// The following describe the exporter's own health rather
+	// than the contents of the monitored Maildirs.
+	walkDuration      *prometheus.HistogramVec
+	walkErrors        *prometheus.CounterVec
+	lastWalkTimestamp *prometheus.GaugeVec
+	watchedInodes     *prometheus.GaugeVec
+	fsnotifyEvents    *prometheus.CounterVec
+	fsnotifyDropped   *prometheus.CounterVec
+
+	reconciliationMismatches *prometheus.CounterVec
+
+	// users and userLifecycleEvents track the exporter's
+	// dynamically discovered set of users, independent of any
+	// single one of them.
+	users               prometheus.Gauge
+	userLifecycleEvents *prometheus.CounterVec
+}
+
+// NewMetrics creates and registers all collectors describing
+// the contents of monitored Maildirs.
+func NewMetrics() *Metrics {
+
+	m := &Metrics{
+		elements: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "elements",
+			Help:      "Number of file system elements found below a user's Maildir.",
+		}, []string{"user"}),
+
+		folders: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "folders",
+			Help:      "Number of folders found below a user's Maildir.",
+		}, []string{"user"}),
+
+		files: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "files",
+			Help:      "Number of files found below a user's Maildir.",
+		}, []string{"user"}),
+
+		size: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "size_bytes",
+			Help:      "Total size in bytes of a user's Maildir, labelled with its Merkle content hash.",
+		}, []string{"user", "content_hash"}),
+
+		newMessages: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "new_messages",
+			Help:      "Number of messages in a mailbox's new/ subdirectory.",
+		}, []string{"user", "mailbox"}),
+
+		curMessages: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cur_messages",
+			Help:      "Number of messages in a mailbox's cur/ subdirectory.",
+		}, []string{"user", "mailbox"}),
+
+		tmpMessages: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "tmp_messages",
+			Help:      "Number of messages in a mailbox's tmp/ subdirectory.",
+		}, []string{"user", "mailbox"}),
+
+		messagesByFlag: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_by_flag_total",
+			Help:      "Number of messages observed carrying a given Maildir info flag (e.g. D, F, P, R, S, T).",
+		}, []string{"user", "mailbox", "flag"}),
+
+		oldestMessageTimestamp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "oldest_message_timestamp_seconds",
+			Help:      "Modification time of the oldest message found in a mailbox.",
+		}, []string{"user", "mailbox"}),
+
+		newestMessageTimestamp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "newest_message_timestamp_seconds",
+			Help:      "Modification time of the newest message found in a mailbox.",
+		}, []string{"user", "mailbox"}),
+
+		messageSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "message_size_bytes",
+			Help:      "Size distribution of messages found in a mailbox.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+		}, []string{"user", "mailbox"}),
+
+		walkDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace + "_exporter",
+			Name:      "walk_duration_seconds",
+			Help:      "Time it took to walk a user's Maildir.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"user"}),
+
+		walkErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace + "_exporter",
+			Name:      "walk_errors_total",
+			Help:      "Number of errors encountered while walking a user's Maildir, by error class.",
+		}, []string{"user", "class"}),
+
+		lastWalkTimestamp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace + "_exporter",
+			Name:      "last_walk_timestamp_seconds",
+			Help:      "Unix timestamp of the last completed walk of a user's Maildir.",
+		}, []string{"user"}),
+
+		watchedInodes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace + "_exporter",
+			Name:      "watched_inodes",
+			Help:      "Number of paths currently registered with a user's Backend watcher.",
+		}, []string{"user"}),
+
+		fsnotifyEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace + "_exporter",
+			Name:      "fsnotify_events_total",
+			Help:      "Number of file system change events observed, by operation.",
+		}, []string{"user", "op"}),
+
+		fsnotifyDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace + "_exporter",
+			Name:      "fsnotify_dropped_total",
+			Help:      "Number of file system change events whose walk trigger was dropped because a walk was already in progress.",
+		}, []string{"user"}),
+
+		reconciliationMismatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace + "_exporter",
+			Name:      "reconciliation_mismatches_total",
+			Help:      "Number of times a periodic full walk found the incrementally updated counters had drifted from the true state.",
+		}, []string{"user"}),
+
+		users: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace + "_exporter",
+			Name:      "users",
+			Help:      "Number of users currently discovered across all configured Maildir roots.",
+		}),
+
+		userLifecycleEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace + "_exporter",
+			Name:      "user_lifecycle_events_total",
+			Help:      "Number of users added or removed across all configured Maildir roots.",
+		}, []string{"event"}),
+	}
+
+	prometheus.MustRegister(
+		m.elements,
+		m.folders,
+		m.files,
+		m.size,
+		m.newMessages,
+		m.curMessages,
+		m.tmpMessages,
+		m.messagesByFlag,
+		m.oldestMessageTimestamp,
+		m.newestMessageTimestamp,
+		m.messageSize,
+		m.walkDuration,
+		m.walkErrors,
+		m.lastWalkTimestamp,
+		m.watchedInodes,
+		m.fsnotifyEvents,
+		m.fsnotifyDropped,
+		m.reconciliationMismatches,
+		m.users,
+		m.userLifecycleEvents,
+	)
+
+	return m
+}
+
+// unregisterUser removes every per-user label series exported
+// for userPath, so that a deprovisioned user's data does not
+// linger in scrapes indefinitely.
+func (m *Metrics) unregisterUser(userPath string) {
+
+	labels := prometheus.Labels{"user": userPath}
+
+	m.elements.DeletePartialMatch(labels)
+	m.folders.DeletePartialMatch(labels)
+	m.files.DeletePartialMatch(labels)
+	m.size.DeletePartialMatch(labels)
+	m.newMessages.DeletePartialMatch(labels)
+	m.curMessages.DeletePartialMatch(labels)
+	m.tmpMessages.DeletePartialMatch(labels)
+	m.messagesByFlag.DeletePartialMatch(labels)
+	m.oldestMessageTimestamp.DeletePartialMatch(labels)
+	m.newestMessageTimestamp.DeletePartialMatch(labels)
+	m.messageSize.DeletePartialMatch(labels)
+	m.walkDuration.DeletePartialMatch(labels)
+	m.walkErrors.DeletePartialMatch(labels)
+	m.lastWalkTimestamp.DeletePartialMatch(labels)
+	m.watchedInodes.DeletePartialMatch(labels)
+	m.fsnotifyEvents.DeletePartialMatch(labels)
+	m.fsnotifyDropped.DeletePartialMatch(labels)
+	m.reconciliationMismatches.DeletePartialMatch(labels)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-pluto/maildir_exporter/backend"
+)
+
+// fullWalkInterval bounds how long steady-state operation can
+// rely purely on incremental updates before a full walk is
+// forced to catch anything applyDelta could not reason about
+// and to correct any drift in the estimated counters.
+var fullWalkInterval = flag.Duration("full-walk-interval", time.Hour,
+	"Interval at which a full walk of each user's Maildir is forced, even if incremental updates appear to be keeping up.")
+
+// applyDelta attempts to fold a single file system event
+// directly into this user's running counters, without a full
+// walk. It returns false for any event it cannot account for
+// precisely, leaving the caller to fall back to a full walk.
+func (m *UserMaildir) applyDelta(metrics *Metrics, ev backend.Event) bool {
+
+	if ev.Op != backend.Create {
+		// Writes can change a file's size without telling us the
+		// old one, removes leave nothing left to Stat, and
+		// renames may cross mailboxes - none of these can be
+		// folded into the running counters precisely.
+		return false
+	}
+
+	entry, err := m.backend.Stat(ev.Path)
+	if err != nil {
+		// The path may already be gone again, or this Backend
+		// may not support Stat on it; either way, let a full
+		// walk sort it out.
+		return false
+	}
+
+	if entry.IsDir {
+		// A newly created directory needs its own Backend watch
+		// and may itself contain messages; only a full walk
+		// discovers that.
+		return false
+	}
+
+	m.countersMu.Lock()
+	m.counters.elements++
+	m.counters.files++
+	m.counters.size += float64(entry.Size)
+	m.estimated = true
+	counters := m.counters
+	m.countersMu.Unlock()
+
+	metrics.elements.With(prometheus.Labels{"user": m.userPath}).Set(counters.elements)
+	metrics.files.With(prometheus.Labels{"user": m.userPath}).Set(counters.files)
+
+	m.applyMailboxDelta(metrics, ev.Path, entry)
+
+	return true
+}
+
+// applyMailboxDelta folds a single newly created message file
+// straight into its mailbox's running counters, the incremental
+// counterpart to the Maildir-semantic bookkeeping walk performs
+// during a full walk. It is a no-op for files outside a new/,
+// cur/ or tmp/ subdirectory, since those carry no Maildir
+// semantics.
+func (m *UserMaildir) applyMailboxDelta(metrics *Metrics, path string, entry backend.Entry) {
+
+	parent := filepath.Dir(path)
+	subdir := filepath.Base(parent)
+
+	if subdir != "new" && subdir != "cur" && subdir != "tmp" {
+		return
+	}
+
+	mailbox := m.mailboxForSubdir(filepath.Dir(parent))
+
+	m.mailboxesMu.Lock()
+
+	if m.mailboxes == nil {
+		m.mailboxes = make(map[string]*mailboxStats)
+	}
+
+	stats, ok := m.mailboxes[mailbox]
+	if !ok {
+		stats = newMailboxStats()
+		m.mailboxes[mailbox] = stats
+	}
+
+	switch subdir {
+	case "new":
+		stats.newMessages++
+	case "cur":
+		stats.curMessages++
+	case "tmp":
+		stats.tmpMessages++
+	}
+
+	var flags []byte
+	if subdir == "cur" {
+		flags = parseMaildirFlags(entry.Name)
+		for _, flag := range flags {
+			stats.flagCounts[flag]++
+		}
+	}
+
+	stats.observeTimestamp(float64(entry.ModTime.Unix()))
+
+	newMessages, curMessages, tmpMessages := stats.newMessages, stats.curMessages, stats.tmpMessages
+	oldest, newest := stats.oldestMessage, stats.newestMessage
+
+	m.mailboxesMu.Unlock()
+
+	labels := prometheus.Labels{"user": m.userPath, "mailbox": mailbox}
+
+	metrics.newMessages.With(labels).Set(newMessages)
+	metrics.curMessages.With(labels).Set(curMessages)
+	metrics.tmpMessages.With(labels).Set(tmpMessages)
+	metrics.oldestMessageTimestamp.With(labels).Set(oldest)
+	metrics.newestMessageTimestamp.With(labels).Set(newest)
+	metrics.messageSize.With(labels).Observe(float64(entry.Size))
+
+	for _, flag := range flags {
+		metrics.messagesByFlag.With(prometheus.Labels{
+			"user":    m.userPath,
+			"mailbox": mailbox,
+			"flag":    string(flag),
+		}).Inc()
+	}
+}
+
+// reconcileLoop periodically forces a full walk of this user's
+// Maildir at fullWalkInterval, bounding how far incremental
+// updates can drift from the true state before they are
+// checked and corrected in walk's reconciliation step.
+func (m *UserMaildir) reconcileLoop(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+
+		case <-ticker.C:
+
+			select {
+			case m.walkTrigger <- struct{}{}:
+			default:
+				// The walk goroutine is busy with a walk; the
+				// interval will simply be checked again next tick.
+			}
+
+		case <-m.done:
+			return
+		}
+	}
+}
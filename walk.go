@@ -2,50 +2,61 @@ package main
 
 import (
 	"fmt"
-	"hash"
 	"os"
+	"time"
 
-	"crypto/sha512"
-	"io/ioutil"
 	"path/filepath"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-pluto/maildir_exporter/backend"
 )
 
-// walkRootMaildir expects the path to the monitored
-// node's Maildir directory containing one folder per
-// user in the system. It creates initial structures
-// for per user file system walks.
-func walkRootMaildir(maildirRootPath string) ([]*UserMaildir, error) {
+// walkRootMaildir expects the path to the monitored node's
+// Maildir directory containing one folder per user in the
+// system. backendURL selects the storage backend to walk and
+// watch it through (see package backend); backendUser and
+// backendPassword authenticate against it where applicable,
+// and pollInterval configures polling-based backends. It
+// creates initial structures for per user file system walks.
+func walkRootMaildir(maildirRootPath, backendURL, backendUser, backendPassword string, pollInterval time.Duration) ([]*UserMaildir, error) {
 
-	i := 0
 	userMaildirs := make([]*UserMaildir, 0, 30)
 
+	rootBackend, err := backend.New(backendURL, backendUser, backendPassword, pollInterval)
+	if err != nil {
+		return nil, err
+	}
+	defer rootBackend.Close()
+
 	// Retrieve all file system elements in maildirRootPath.
-	files, _ := ioutil.ReadDir(maildirRootPath)
-	for _, f := range files {
+	entries, err := rootBackend.ReadDir(maildirRootPath)
+	if err != nil {
+		return nil, err
+	}
 
-		if f.IsDir() {
+	for _, e := range entries {
 
-			// Create new file system watcher for this user.
-			w, err := fsnotify.NewWatcher()
+		if e.IsDir {
+
+			// Create a dedicated backend instance for this user
+			// so that its watch goroutine and state stay
+			// independent from its siblings.
+			b, err := backend.New(backendURL, backendUser, backendPassword, pollInterval)
 			if err != nil {
 				return nil, err
 			}
 
 			// Create new item for this user.
 			userMaildirs = append(userMaildirs, &UserMaildir{
-				userPath:     filepath.Join(maildirRootPath, f.Name()),
-				watcher:      w,
+				userPath:     filepath.Join(maildirRootPath, e.Name),
+				backend:      b,
 				walkTrigger:  make(chan struct{}),
 				watchTrigger: make(chan struct{}),
 				done:         make(chan struct{}),
 			})
-
-			i++
 		}
 	}
 
@@ -67,68 +78,103 @@ func (m *UserMaildir) walk(logger log.Logger, metrics *Metrics, done chan struct
 
 		case <-m.walkTrigger:
 
+			start := time.Now()
+
 			var numElems float64 = 0.0
 			var numFolders float64 = 0.0
 			var numFiles float64 = 0.0
 			var numSize float64 = 0.0
-			var shaHash hash.Hash = sha512.New()
-
-			err := filepath.Walk(m.userPath, func(path string, info os.FileInfo, err error) error {
 
-				if err != nil {
-					return err
-				}
-
-				// Do not include the user's Maildir root path,
-				// but add it to this user's file system watcher.
-				if path == m.userPath {
-
-					absPath, err := filepath.Abs(path)
-					if err != nil {
-						return err
+			// Accumulates the Maildir-semantic counters for
+			// every mailbox discovered below the user's root,
+			// keyed by its mailbox label (e.g. "INBOX", "Sent").
+			mailboxes := make(map[string]*mailboxStats)
+
+			// Add the user's Maildir root itself to this user's
+			// Backend watcher before recursing into it.
+			_, err := m.backend.Watch(m.userPath)
+
+			if err == nil {
+				err = m.walkMaildir(m.userPath, func(path string, entry backend.Entry) error {
+
+					if entry.IsDir {
+
+						numFolders++
+
+						// Add this sub directory to this user's watcher.
+						if _, err := m.backend.Watch(path); err != nil {
+							return err
+						}
+
+						// A directory named new, cur or tmp marks its
+						// parent as a mailbox, wherever that parent sits
+						// below the user's root. Register it with zeroed
+						// counters so that empty mailboxes still surface
+						// in the exported metrics.
+						base := filepath.Base(path)
+						if base == "new" || base == "cur" || base == "tmp" {
+
+							mailbox := m.mailboxForSubdir(filepath.Dir(path))
+							if _, ok := mailboxes[mailbox]; !ok {
+								mailboxes[mailbox] = newMailboxStats()
+							}
+						}
+					} else {
+
+						numFiles++
+
+						// Messages only carry Maildir semantics when
+						// they live directly below a new/, cur/ or
+						// tmp/ subdirectory of a mailbox.
+						parent := filepath.Dir(path)
+						subdir := filepath.Base(parent)
+
+						if subdir == "new" || subdir == "cur" || subdir == "tmp" {
+
+							mailbox := m.mailboxForSubdir(filepath.Dir(parent))
+
+							stats, ok := mailboxes[mailbox]
+							if !ok {
+								stats = newMailboxStats()
+								mailboxes[mailbox] = stats
+							}
+
+							switch subdir {
+							case "new":
+								stats.newMessages++
+							case "cur":
+								stats.curMessages++
+							case "tmp":
+								stats.tmpMessages++
+							}
+
+							if subdir == "cur" {
+								for _, flag := range parseMaildirFlags(entry.Name) {
+									stats.flagCounts[flag]++
+								}
+							}
+
+							stats.observeTimestamp(float64(entry.ModTime.Unix()))
+
+							metrics.messageSize.With(prometheus.Labels{
+								"user":    m.userPath,
+								"mailbox": mailbox,
+							}).Observe(float64(entry.Size))
+						}
 					}
 
-					err = m.watcher.Add(absPath)
-					if err != nil {
-						return err
-					}
-
-					return nil
-				}
+					numElems++
+					numSize += float64(entry.Size)
 
-				// Maildirs only consist of folders and files,
-				// thus ignore all other elements.
-				if !(info.IsDir() || info.Mode().IsRegular()) {
 					return nil
-				}
-
-				if info.IsDir() {
-
-					numFolders++
-
-					absPath, err := filepath.Abs(path)
-					if err != nil {
-						return err
-					}
-
-					// Add this sub directory to this user's watcher.
-					err = m.watcher.Add(absPath)
-					if err != nil {
-						return err
-					}
-				} else if info.Mode().IsRegular() {
-					numFiles++
-				}
-
-				numElems++
-				numSize += float64(info.Size())
-
-				// Add element to checksum calculation.
-				shaHash.Write([]byte(path))
-
-				return nil
-			})
+				})
+			}
 			if err != nil {
+				metrics.walkErrors.With(prometheus.Labels{
+					"user":  m.userPath,
+					"class": walkErrorClass(err),
+				}).Inc()
+
 				level.Error(logger).Log(
 					"msg", "error while walking user Maildir",
 					"err", err,
@@ -136,17 +182,77 @@ func (m *UserMaildir) walk(logger log.Logger, metrics *Metrics, done chan struct
 				return
 			}
 
+			metrics.walkDuration.With(prometheus.Labels{"user": m.userPath}).Observe(time.Since(start).Seconds())
+			metrics.lastWalkTimestamp.With(prometheus.Labels{"user": m.userPath}).Set(float64(time.Now().Unix()))
+
+			// Every folder plus the user's Maildir root itself
+			// is registered with this user's Backend watcher.
+			metrics.watchedInodes.With(prometheus.Labels{"user": m.userPath}).Set(numFolders + 1)
+
+			// If counters were last updated incrementally by
+			// applyDelta, check them against this full walk's
+			// ground truth before overwriting them.
+			m.countersMu.Lock()
+			if m.estimated && m.counters != (maildirCounters{numElems, numFolders, numFiles, numSize}) {
+				metrics.reconciliationMismatches.With(prometheus.Labels{"user": m.userPath}).Inc()
+			}
+			m.counters = maildirCounters{elements: numElems, folders: numFolders, files: numFiles, size: numSize}
+			m.estimated = false
+			m.countersMu.Unlock()
+
 			// Set updated metrics in supplied struct.
 			metrics.elements.With(prometheus.Labels{"user": m.userPath}).Set(numElems)
 			metrics.folders.With(prometheus.Labels{"user": m.userPath}).Set(numFolders)
 			metrics.files.With(prometheus.Labels{"user": m.userPath}).Set(numFiles)
 
-			// Include the calculated SHA512 checksum for this Maildir.
+			// Roll up this user's cached per-directory Merkle
+			// hashes into its content hash. Only directories
+			// marked dirty by invalidate since the last walk, or
+			// not hashed before, are actually rehashed.
+			contentHash, err := m.merkleRoot()
+			if err != nil {
+				level.Error(logger).Log(
+					"msg", "error while hashing user Maildir",
+					"err", err,
+				)
+				return
+			}
+
 			metrics.size.With(prometheus.Labels{
-				"user":   m.userPath,
-				"sha512": fmt.Sprintf("%x", shaHash.Sum(nil)),
+				"user":         m.userPath,
+				"content_hash": fmt.Sprintf("%x", contentHash),
 			}).Set(numSize)
 
+			for mailbox, stats := range mailboxes {
+
+				labels := prometheus.Labels{"user": m.userPath, "mailbox": mailbox}
+
+				metrics.newMessages.With(labels).Set(stats.newMessages)
+				metrics.curMessages.With(labels).Set(stats.curMessages)
+				metrics.tmpMessages.With(labels).Set(stats.tmpMessages)
+
+				metrics.oldestMessageTimestamp.With(labels).Set(stats.oldestMessage)
+				metrics.newestMessageTimestamp.With(labels).Set(stats.newestMessage)
+
+				for _, flag := range maildirFlags {
+					if count, ok := stats.flagCounts[flag]; ok {
+						metrics.messagesByFlag.With(prometheus.Labels{
+							"user":    m.userPath,
+							"mailbox": mailbox,
+							"flag":    string(flag),
+						}).Add(count)
+					}
+				}
+			}
+
+			// Hand the freshly walked mailbox state over to
+			// applyMailboxDelta, so incremental updates between
+			// now and the next full walk build on ground truth
+			// rather than a stale or empty map.
+			m.mailboxesMu.Lock()
+			m.mailboxes = mailboxes
+			m.mailboxesMu.Unlock()
+
 			// Signal walk completion downstream.
 			done <- struct{}{}
 
@@ -155,4 +261,32 @@ func (m *UserMaildir) walk(logger log.Logger, metrics *Metrics, done chan struct
 			return
 		}
 	}
+}
+
+// mailboxForSubdir derives the mailbox label for the parent
+// directory of a new/, cur/ or tmp/ subdirectory, relative to
+// this user's Maildir root.
+func (m *UserMaildir) mailboxForSubdir(parent string) string {
+
+	rel, err := filepath.Rel(m.userPath, parent)
+	if err != nil {
+		return parent
+	}
+
+	return mailboxName(rel)
+}
+
+// walkErrorClass classifies an error raised while walking a
+// Maildir for the walk_errors_total label, without exposing
+// potentially sensitive path details in the metric itself.
+func walkErrorClass(err error) string {
+
+	switch {
+	case os.IsNotExist(err):
+		return "not_exist"
+	case os.IsPermission(err):
+		return "permission"
+	default:
+		return "other"
+	}
 }
\ No newline at end of file
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-pluto/maildir_exporter/backend"
+)
+
+// watch consumes file system change events reported by this
+// user's Backend, folding each into the running counters via
+// applyDelta where it can and requesting a full walk otherwise.
+// It runs until events is closed or m.done is closed, and
+// updates the exporter's own health metrics along the way.
+func (m *UserMaildir) watch(logger log.Logger, metrics *Metrics, events <-chan backend.Event) {
+
+	for {
+
+		select {
+
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+
+			if ev.Op == backend.Overflow {
+				// Events may have been lost anywhere under this
+				// user's tree, so per-path invalidate can no
+				// longer be trusted; drop the whole Merkle cache
+				// instead, or content_hash would keep reusing
+				// stale hashes for any directory that doesn't
+				// independently receive a later event.
+				m.invalidateAll()
+
+				level.Error(logger).Log(
+					"msg", "backend watcher overflowed, forcing full walk",
+					"user", m.userPath,
+				)
+			} else {
+				// Only the changed path and its ancestors need
+				// to be rehashed on the next walk, whether that
+				// walk ends up being a full one or not.
+				m.invalidate(ev.Path)
+			}
+
+			metrics.fsnotifyEvents.With(prometheus.Labels{
+				"user": m.userPath,
+				"op":   fsnotifyOpLabel(ev.Op),
+			}).Inc()
+
+			// Try to fold this event straight into the running
+			// counters. Only a genuinely ambiguous event (a new
+			// directory, a removal, an unrecognised op, ...)
+			// needs a full walk to stay accurate.
+			if m.applyDelta(metrics, ev) {
+				continue
+			}
+
+			select {
+			case m.walkTrigger <- struct{}{}:
+			default:
+				// The walk goroutine is busy with a walk; drop
+				// this trigger rather than blocking the watch loop.
+				metrics.fsnotifyDropped.With(prometheus.Labels{"user": m.userPath}).Inc()
+			}
+
+		case <-m.done:
+			level.Debug(logger).Log("msg", fmt.Sprintf("done watching Maildir for %s", m.userPath))
+			return
+		}
+	}
+}
+
+// fsnotifyOpLabel maps a backend.EventOp to the label value
+// used by the maildir_exporter_fsnotify_events_total counter.
+func fsnotifyOpLabel(op backend.EventOp) string {
+
+	switch op {
+	case backend.Create:
+		return "create"
+	case backend.Write:
+		return "write"
+	case backend.Remove:
+		return "remove"
+	case backend.Rename:
+		return "rename"
+	case backend.Overflow:
+		return "overflow"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/go-pluto/maildir_exporter/backend"
+)
+
+// TestWatchOverflowInvalidatesWholeMerkleCache reproduces the
+// bug where a backend.Overflow event left the Merkle cache
+// untouched: content_hash would then keep reusing stale hashes
+// for any directory that never independently receives a later
+// fsnotify event, even though a full walk picks up the rest of
+// the state correctly.
+func TestWatchOverflowInvalidatesWholeMerkleCache(t *testing.T) {
+
+	b := newFakeBackend()
+	b.dirs["/u"] = []backend.Entry{{Name: "cur", IsDir: true}}
+	b.dirs[filepath.Join("/u", "cur")] = []backend.Entry{
+		{Name: "msg1", ModTime: time.Unix(1, 0)},
+	}
+
+	m := newTestUserMaildir(b)
+	m.walkTrigger = make(chan struct{}, 1)
+	m.done = make(chan struct{})
+
+	if _, err := m.merkleHash("/u"); err != nil {
+		t.Fatalf("merkleHash: %v", err)
+	}
+
+	if len(m.merkleCache) == 0 {
+		t.Fatalf("expected merkleCache to be populated before the overflow event")
+	}
+
+	events := make(chan backend.Event, 1)
+	events <- backend.Event{Op: backend.Overflow}
+
+	metrics := NewMetrics()
+
+	done := make(chan struct{})
+	go func() {
+		m.watch(log.NewNopLogger(), metrics, events)
+		close(done)
+	}()
+
+	select {
+	case <-m.walkTrigger:
+	case <-time.After(time.Second):
+		t.Fatal("watch did not trigger a full walk on overflow")
+	}
+
+	close(m.done)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watch did not return after m.done was closed")
+	}
+
+	if len(m.merkleCache) != 0 {
+		t.Errorf("expected merkleCache to be cleared after an overflow event, still has %d entries", len(m.merkleCache))
+	}
+}